@@ -1,16 +1,28 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/fatih/color"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v6/apis/volumesnapshot/v1"
+	snapshotclientset "github.com/kubernetes-csi/external-snapshotter/client/v6/clientset/versioned"
 
 	corev1 "k8s.io/api/core/v1"
 	k8serr "k8s.io/apimachinery/pkg/api/errors"
@@ -18,8 +30,13 @@ import (
 
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/util/wait"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/klog/v2"
 )
 
 const (
@@ -31,26 +48,55 @@ const (
 	DefaultSize        = "100m"
 	DefaultCount       = 3
 
-	APICallRetryInterval     = 5000 * time.Millisecond
+	DefaultIOImage     = "docker.io/ljishen/fio:latest"
+	DefaultIOBlockSize = "4k"
+	DefaultIODuration  = "30s"
+	DefaultIOJobs      = 1
+	DefaultIOSize      = "50m"
+
+	DefaultAPIRetryInterval  = 5 * time.Second
+	DefaultAPIRetryMax       = 2 * time.Minute
 	DefaultPollTimeout       = 30 * time.Minute
 	DefaultSleepMilliseconds = 3000
+
+	DefaultLogFormat = "text"
+	DefaultRepeat    = 1
 )
 
 var (
-	fs             *flag.FlagSet
-	resultsFile    *string
-	noResultsFile  *bool
-	resultsStdout  *bool
-	noResults      *bool
-	kubeconfig     *string
-	prefix         *string
-	namespace      *string
-	image          *string
-	storageClass   *string
-	count          *int
-	reqStorageSize *string
-	Command        = []string{"tail", "-f", "/dev/null"}
-	Labels         = map[string]string{
+	fs                  *flag.FlagSet
+	resultsFile         *string
+	noResultsFile       *bool
+	resultsStdout       *bool
+	noResults           *bool
+	kubeconfig          *string
+	prefix              *string
+	namespace           *string
+	image               *string
+	storageClass        *string
+	count               *int
+	reqStorageSize      *string
+	snapshotClass       *string
+	restoreFromSnapshot *string
+	cloneFrom           *bool
+	expandTo            *string
+	ioBenchmark         *bool
+	ioImage             *string
+	ioBlockSize         *string
+	ioDuration          *string
+	ioJobs              *int
+	ioSize              *string
+	apiRetryInterval    *time.Duration
+	apiRetryMax         *time.Duration
+	pollTimeout         *time.Duration
+	podTemplate         *string
+	pvcTemplate         *string
+	metricsAddr         *string
+	logFormat           *string
+	repeat              *int
+	interval            *time.Duration
+	Command             = []string{"tail", "-f", "/dev/null"}
+	Labels              = map[string]string{
 		"app": "shiny-potato",
 	}
 )
@@ -60,23 +106,47 @@ type PodWithPvc struct {
 	Command   string
 	Pvc       []*Pvc
 	Pod       []*Pod
+	Snapshot  []*Snapshot `json:",omitempty"`
 }
 
 type Pod struct {
-	Name      *string
-	Namespace *string               `json:"-"`
-	Image     *string               `json:"-"`
-	ClientSet *kubernetes.Clientset `json:"-"`
-	Timings   Timing
+	Name       *string
+	Namespace  *string               `json:"-"`
+	Image      *string               `json:"-"`
+	ClientSet  *kubernetes.Clientset `json:"-"`
+	RestConfig *rest.Config          `json:"-"`
+	Template   *corev1.Pod           `json:"-"`
+	Timings    Timing
+	IOResults  *IOResults `json:",omitempty"`
+}
+
+// IOResults holds the in-pod fio micro-benchmark results gathered by
+// --io-benchmark once the pod is Ready. Bandwidth and IOPS are summed across
+// read and write, but latency percentiles are kept separate per direction:
+// summing two independent latency distributions' percentiles is statistically
+// meaningless (for the default randrw 50/50 mix it roughly doubles them).
+type IOResults struct {
+	BandwidthMBps     float64
+	IOPS              float64
+	ReadLatencyP50Ms  float64
+	ReadLatencyP99Ms  float64
+	WriteLatencyP50Ms float64
+	WriteLatencyP99Ms float64
 }
 
 type Pvc struct {
-	Name         *string
-	Namespace    *string               `json:"-"`
-	ClientSet    *kubernetes.Clientset `json:"-"`
-	Size         *string
-	StorageClass *string
-	Timings      Timing
+	Name                *string
+	Namespace           *string               `json:"-"`
+	ClientSet           *kubernetes.Clientset `json:"-"`
+	Size                *string
+	StorageClass        *string
+	RestoreFromSnapshot *string                       `json:"-"`
+	SourcePVC           *string                       `json:",omitempty"`
+	TargetSize          *string                       `json:",omitempty"`
+	Template            *corev1.PersistentVolumeClaim `json:"-"`
+	Timings             Timing
+	CloneTiming         *Timing `json:",omitempty"`
+	ExpandTiming        *Timing `json:",omitempty"`
 }
 
 type Timing struct {
@@ -86,17 +156,240 @@ type Timing struct {
 }
 
 type Resource interface {
-	Create() error
-	WaitCreate() error
-	Delete() error
-	WaitDelete() error
+	Create(ctx context.Context) error
+	WaitCreate(ctx context.Context) error
+	Delete(ctx context.Context) error
+	WaitDelete(ctx context.Context) error
+}
+
+// isRetryableAPIError reports whether err looks like a transient API server
+// or network blip worth retrying, rather than a fatal condition.
+func isRetryableAPIError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if k8serr.IsServerTimeout(err) || k8serr.IsTooManyRequests(err) || k8serr.IsInternalError(err) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	msg := err.Error()
+	return strings.Contains(msg, "EOF") || strings.Contains(msg, "connection refused")
+}
+
+// withRetry runs fn, retrying with exponential backoff while fn's error is
+// retryable, up to a total budget of maxDuration or until ctx is cancelled.
+// Non-retryable errors (including AlreadyExists/NotFound, which callers
+// handle themselves) are returned immediately.
+func withRetry(ctx context.Context, interval, maxDuration time.Duration, fn func() error) error {
+	deadline := time.Now().Add(maxDuration)
+	backoff := interval
+
+	for {
+		err := fn()
+		if err == nil || !isRetryableAPIError(err) {
+			return err
+		}
+
+		if time.Now().After(deadline) {
+			return err
+		}
+
+		sleep := backoff
+		if remaining := time.Until(deadline); remaining < sleep {
+			sleep = remaining
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sleep):
+		}
+
+		backoff *= 2
+		if backoff > maxDuration {
+			backoff = maxDuration
+		}
+	}
+}
+
+/////////////
+// Metrics //
+/////////////
+// metricBuckets spans the range of latencies this tool cares about, from a
+// fast local bind (0.1s) up to a stuck provisioner (30min).
+var metricBuckets = []float64{0.1, 0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300, 600, 900, 1200, 1800}
+
+var (
+	pvcBindSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "shiny_potato_pvc_bind_seconds",
+		Help:    "Time from PersistentVolumeClaim creation until it is Bound.",
+		Buckets: metricBuckets,
+	})
+	podReadySeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "shiny_potato_pod_ready_seconds",
+		Help:    "Time from Pod creation until it is Ready.",
+		Buckets: metricBuckets,
+	})
+	pvcDeleteSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "shiny_potato_pvc_delete_seconds",
+		Help:    "Time from PersistentVolumeClaim deletion request until it is gone.",
+		Buckets: metricBuckets,
+	})
+	podDeleteSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "shiny_potato_pod_delete_seconds",
+		Help:    "Time from Pod deletion request until it is gone.",
+		Buckets: metricBuckets,
+	})
+
+	createErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shiny_potato_create_errors_total",
+		Help: "Count of errors encountered creating or waiting on a resource, by reason.",
+	}, []string{"reason"})
+	deleteErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shiny_potato_delete_errors_total",
+		Help: "Count of errors encountered deleting or waiting on a resource, by reason.",
+	}, []string{"reason"})
+
+	inFlightResources = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "shiny_potato_in_flight_resources",
+		Help: "Number of PVCs/Pods/Snapshots currently created but not yet deleted.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		pvcBindSeconds, podReadySeconds, pvcDeleteSeconds, podDeleteSeconds,
+		createErrorsTotal, deleteErrorsTotal, inFlightResources,
+	)
+}
+
+// errorReason classifies err for the create/delete error counters.
+func errorReason(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case k8serr.IsAlreadyExists(err):
+		return "AlreadyExists"
+	case k8serr.IsNotFound(err):
+		return "NotFound"
+	case isRetryableAPIError(err):
+		return "Retryable"
+	default:
+		return "Fatal"
+	}
+}
+
+// serveMetrics starts a best-effort HTTP server exposing the Prometheus
+// metrics at /metrics on addr. It runs in the background for the lifetime of
+// the process; if the server fails to start or exits, the error is logged and
+// the run carries on without metrics.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			klog.ErrorS(err, "metrics server exited")
+		}
+	}()
+
+	klog.InfoS("metrics server listening", "addr", addr)
+}
+
+// jsonSink is a minimal logr.LogSink that writes one JSON object per line,
+// used behind --log-format=json so results can be scraped by CI pipelines and
+// Grafana instead of parsed from free-form text.
+type jsonSink struct {
+	name   string
+	values []interface{}
+}
+
+var _ logr.LogSink = (*jsonSink)(nil)
+
+func (s *jsonSink) Init(info logr.RuntimeInfo) {}
+
+func (s *jsonSink) Enabled(level int) bool { return true }
+
+func (s *jsonSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.write("info", msg, nil, keysAndValues)
+}
+
+func (s *jsonSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.write("error", msg, err, keysAndValues)
+}
+
+func (s *jsonSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return &jsonSink{name: s.name, values: append(append([]interface{}{}, s.values...), keysAndValues...)}
+}
+
+func (s *jsonSink) WithName(name string) logr.LogSink {
+	return &jsonSink{name: name, values: s.values}
+}
+
+func (s *jsonSink) write(level, msg string, err error, keysAndValues []interface{}) {
+	entry := map[string]interface{}{
+		"ts":    time.Now().Format(time.RFC3339Nano),
+		"level": level,
+		"msg":   msg,
+	}
+	if s.name != "" {
+		entry["logger"] = s.name
+	}
+	if err != nil {
+		entry["err"] = err.Error()
+	}
+
+	all := append(append([]interface{}{}, s.values...), keysAndValues...)
+	for i := 0; i+1 < len(all); i += 2 {
+		if key, ok := all[i].(string); ok {
+			entry[key] = all[i+1]
+		}
+	}
+
+	line, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		fmt.Println(msg)
+		return
+	}
+	fmt.Println(string(line))
+}
+
+// configureLogging wires klog's structured (InfoS/ErrorS) calls to a JSON
+// sink when --log-format=json, leaving klog's default text formatting in
+// place otherwise.
+func configureLogging(format string) {
+	if format == "json" {
+		klog.SetLogger(logr.New(&jsonSink{}))
+	}
 }
 
 ///////////////////////////
 // PersistentVolumeClaim //
 ///////////////////////////
-func newPvClaim(ns, name, size string, sc *string) *corev1.PersistentVolumeClaim {
-	return &corev1.PersistentVolumeClaim{
+// newPvClaim builds the PVC for a run. If template is set (--pvc-template),
+// it is used as-is, with only metadata.name/namespace and spec.dataSource
+// overridden so it binds to this run's generated pair and, if this is a
+// clone/restore PVC, still provisions from the right source; everything else
+// (access modes, volume mode, resource requests, ...) comes from the
+// template.
+func newPvClaim(ns, name, size string, sc *string, dataSource *corev1.TypedLocalObjectReference, template *corev1.PersistentVolumeClaim) *corev1.PersistentVolumeClaim {
+	if template != nil {
+		pvc := template.DeepCopy()
+		pvc.ObjectMeta.Name = name
+		pvc.ObjectMeta.Namespace = ns
+		if dataSource != nil {
+			pvc.Spec.DataSource = dataSource
+		}
+		return pvc
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
 			Namespace: ns,
@@ -110,34 +403,68 @@ func newPvClaim(ns, name, size string, sc *string) *corev1.PersistentVolumeClaim
 					corev1.ResourceName(corev1.ResourceStorage): resource.MustParse(size),
 				},
 			},
+			DataSource: dataSource,
 		},
 	}
+
+	return pvc
+}
+
+// dataSource builds the spec.dataSource reference for a PVC created from a
+// prior VolumeSnapshot (--restore-from-snapshot) or cloned from another PVC
+// (--clone-from). The two are mutually exclusive; snapshot restore wins if
+// both are somehow set.
+func (p *Pvc) dataSource() *corev1.TypedLocalObjectReference {
+	if p.RestoreFromSnapshot != nil && *p.RestoreFromSnapshot != "" {
+		snapshotAPIGroup := snapshotv1.GroupName
+		return &corev1.TypedLocalObjectReference{
+			APIGroup: &snapshotAPIGroup,
+			Kind:     "VolumeSnapshot",
+			Name:     *p.RestoreFromSnapshot,
+		}
+	}
+
+	if p.SourcePVC != nil && *p.SourcePVC != "" {
+		return &corev1.TypedLocalObjectReference{
+			Kind: "PersistentVolumeClaim",
+			Name: *p.SourcePVC,
+		}
+	}
+
+	return nil
 }
 
-func (p *Pvc) Create() error {
-	fmt.Printf(">>> [PVCLAIM] %v/%v creating...\n", *p.Namespace, *p.Name)
-	pvc := newPvClaim(*p.Namespace, *p.Name, *p.Size, p.StorageClass)
+func (p *Pvc) Create(ctx context.Context) error {
+	klog.InfoS("pvc creating", "namespace", *p.Namespace, "name", *p.Name)
+	pvc := newPvClaim(*p.Namespace, *p.Name, *p.Size, p.StorageClass, p.dataSource(), p.Template)
 	p.Timings.Start = time.Now()
-	_, err := p.ClientSet.CoreV1().PersistentVolumeClaims(*p.Namespace).Create(context.TODO(), pvc, metav1.CreateOptions{})
+	err := withRetry(ctx, *apiRetryInterval, *apiRetryMax, func() error {
+		_, err := p.ClientSet.CoreV1().PersistentVolumeClaims(*p.Namespace).Create(ctx, pvc, metav1.CreateOptions{})
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	fmt.Printf(">>> [PVCLAIM] %v/%v created\n", *p.Namespace, *p.Name)
+	klog.InfoS("pvc created", "namespace", *p.Namespace, "name", *p.Name)
 	return nil
 }
 
-func (p *Pvc) WaitCreate() error {
-	return wait.PollImmediate(APICallRetryInterval, DefaultPollTimeout, func() (bool, error) {
-		fmt.Printf(">>> [PVCLAIM] %v/%v waiting to be bound....\n", *p.Namespace, *p.Name)
-		pvc, err := p.ClientSet.CoreV1().PersistentVolumeClaims(*p.Namespace).Get(context.TODO(), *p.Name, metav1.GetOptions{})
+func (p *Pvc) WaitCreate(ctx context.Context) error {
+	return wait.PollUntilContextTimeout(ctx, *apiRetryInterval, *pollTimeout, true, func(ctx context.Context) (bool, error) {
+		klog.InfoS("pvc waiting to be bound", "namespace", *p.Namespace, "name", *p.Name)
+		pvc, err := p.ClientSet.CoreV1().PersistentVolumeClaims(*p.Namespace).Get(ctx, *p.Name, metav1.GetOptions{})
 		if err != nil {
+			if isRetryableAPIError(err) {
+				return false, nil
+			}
 			return false, err
 		}
 
 		if pvc.Status.Phase == corev1.ClaimBound {
 			p.Timings.End = time.Now()
 			p.Timings.Duration = time.Since(p.Timings.Start).String()
-			logSuccess(fmt.Sprintf(">>> [PVCLAIM] %v/%v bound, time elapsed: %v\n", *p.Namespace, *p.Name, p.Timings.Duration))
+			pvcBindSeconds.Observe(time.Since(p.Timings.Start).Seconds())
+			klog.InfoS("pvc bound", "namespace", *p.Namespace, "name", *p.Name, "duration", p.Timings.Duration)
 			return true, nil
 		}
 
@@ -145,44 +472,219 @@ func (p *Pvc) WaitCreate() error {
 	})
 }
 
-func (p *Pvc) Delete() error {
-	fmt.Printf(">>> [PVCLAIM] %v/%v deleting...\n", *p.Namespace, *p.Name)
+func (p *Pvc) Delete(ctx context.Context) error {
+	klog.InfoS("pvc deleting", "namespace", *p.Namespace, "name", *p.Name)
 	p.Timings.Start = time.Now()
 	deletePolicy := metav1.DeletePropagationForeground
-	err := p.ClientSet.CoreV1().PersistentVolumeClaims(*p.Namespace).
-		Delete(context.TODO(), *p.Name, metav1.DeleteOptions{PropagationPolicy: &deletePolicy})
+	err := withRetry(ctx, *apiRetryInterval, *apiRetryMax, func() error {
+		return p.ClientSet.CoreV1().PersistentVolumeClaims(*p.Namespace).
+			Delete(ctx, *p.Name, metav1.DeleteOptions{PropagationPolicy: &deletePolicy})
+	})
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf(">>> [PVCLAIM] %v/%v deletion started\n", *p.Namespace, *p.Name)
+	klog.InfoS("pvc deletion started", "namespace", *p.Namespace, "name", *p.Name)
 	return nil
 }
 
-func (p *Pvc) WaitDelete() error {
-	return wait.PollImmediate(APICallRetryInterval, DefaultPollTimeout, func() (bool, error) {
-		fmt.Printf(">>> [PVCLAIM] %v/%v waiting to be deleted...\n", *p.Namespace, *p.Name)
-		_, err := p.ClientSet.CoreV1().PersistentVolumeClaims(*p.Namespace).Get(context.TODO(), *p.Name, metav1.GetOptions{})
+func (p *Pvc) WaitDelete(ctx context.Context) error {
+	return wait.PollUntilContextTimeout(ctx, *apiRetryInterval, *pollTimeout, true, func(ctx context.Context) (bool, error) {
+		klog.InfoS("pvc waiting to be deleted", "namespace", *p.Namespace, "name", *p.Name)
+		_, err := p.ClientSet.CoreV1().PersistentVolumeClaims(*p.Namespace).Get(ctx, *p.Name, metav1.GetOptions{})
 		if k8serr.IsNotFound(err) {
 			p.Timings.End = time.Now()
 			p.Timings.Duration = time.Since(p.Timings.Start).String()
+			pvcDeleteSeconds.Observe(time.Since(p.Timings.Start).Seconds())
 
-			logSuccess(fmt.Sprintf(">>> [PVCLAIM] %v/%v deleted, time elapsed: %v\n", *p.Namespace, *p.Name, p.Timings.Duration))
+			klog.InfoS("pvc deleted", "namespace", *p.Namespace, "name", *p.Name, "duration", p.Timings.Duration)
 			return true, nil
 		}
 
+		if isRetryableAPIError(err) {
+			return false, nil
+		}
+
 		return false, err
 	})
 }
 
-func logSuccess(message string) {
-	color.Green(message)
+// Expand patches the PVC's requested storage size to TargetSize. It is used
+// by --expand-to to benchmark online volume expansion.
+func (p *Pvc) Expand(ctx context.Context) error {
+	klog.InfoS("pvc expanding", "namespace", *p.Namespace, "name", *p.Name, "targetSize", *p.TargetSize)
+	p.ExpandTiming = &Timing{Start: time.Now()}
+
+	return withRetry(ctx, *apiRetryInterval, *apiRetryMax, func() error {
+		pvc, err := p.ClientSet.CoreV1().PersistentVolumeClaims(*p.Namespace).Get(ctx, *p.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		pvc.Spec.Resources.Requests[corev1.ResourceStorage] = resource.MustParse(*p.TargetSize)
+		_, err = p.ClientSet.CoreV1().PersistentVolumeClaims(*p.Namespace).Update(ctx, pvc, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+
+		klog.InfoS("pvc expansion requested", "namespace", *p.Namespace, "name", *p.Name)
+		return nil
+	})
+}
+
+func (p *Pvc) WaitExpand(ctx context.Context) error {
+	targetSize := resource.MustParse(*p.TargetSize)
+
+	return wait.PollUntilContextTimeout(ctx, *apiRetryInterval, *pollTimeout, true, func(ctx context.Context) (bool, error) {
+		klog.InfoS("pvc waiting for resize to complete", "namespace", *p.Namespace, "name", *p.Name)
+		pvc, err := p.ClientSet.CoreV1().PersistentVolumeClaims(*p.Namespace).Get(ctx, *p.Name, metav1.GetOptions{})
+		if err != nil {
+			if isRetryableAPIError(err) {
+				return false, nil
+			}
+			return false, err
+		}
+
+		for _, condition := range pvc.Status.Conditions {
+			if (condition.Type == corev1.PersistentVolumeClaimFileSystemResizePending || condition.Type == corev1.PersistentVolumeClaimResizing) &&
+				condition.Status == corev1.ConditionTrue {
+				return false, nil
+			}
+		}
+
+		capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]
+		if ok && capacity.Cmp(targetSize) >= 0 {
+			p.ExpandTiming.End = time.Now()
+			p.ExpandTiming.Duration = time.Since(p.ExpandTiming.Start).String()
+			klog.InfoS("pvc resized", "namespace", *p.Namespace, "name", *p.Name, "duration", p.ExpandTiming.Duration)
+			return true, nil
+		}
+
+		return false, nil
+	})
+}
+
+////////////////////
+// VolumeSnapshot //
+////////////////////
+func newVolumeSnapshot(ns, name, pvcName string, class *string) *snapshotv1.VolumeSnapshot {
+	return &snapshotv1.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels:    Labels,
+		},
+		Spec: snapshotv1.VolumeSnapshotSpec{
+			VolumeSnapshotClassName: class,
+			Source: snapshotv1.VolumeSnapshotSource{
+				PersistentVolumeClaimName: &pvcName,
+			},
+		},
+	}
+}
+
+type Snapshot struct {
+	Name          *string
+	Namespace     *string                      `json:"-"`
+	ClientSet     *snapshotclientset.Clientset `json:"-"`
+	SourcePVC     *string                      `json:"-"`
+	SnapshotClass *string                      `json:"-"`
+	Timings       Timing
+}
+
+func (s *Snapshot) Create(ctx context.Context) error {
+	klog.InfoS("snapshot creating", "namespace", *s.Namespace, "name", *s.Name)
+	snap := newVolumeSnapshot(*s.Namespace, *s.Name, *s.SourcePVC, s.SnapshotClass)
+	s.Timings.Start = time.Now()
+	err := withRetry(ctx, *apiRetryInterval, *apiRetryMax, func() error {
+		_, err := s.ClientSet.SnapshotV1().VolumeSnapshots(*s.Namespace).Create(ctx, snap, metav1.CreateOptions{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	klog.InfoS("snapshot created", "namespace", *s.Namespace, "name", *s.Name)
+	return nil
+}
+
+func (s *Snapshot) WaitCreate(ctx context.Context) error {
+	return wait.PollUntilContextTimeout(ctx, *apiRetryInterval, *pollTimeout, true, func(ctx context.Context) (bool, error) {
+		klog.InfoS("snapshot waiting to be ready", "namespace", *s.Namespace, "name", *s.Name)
+		snap, err := s.ClientSet.SnapshotV1().VolumeSnapshots(*s.Namespace).Get(ctx, *s.Name, metav1.GetOptions{})
+		if err != nil {
+			if isRetryableAPIError(err) {
+				return false, nil
+			}
+			return false, err
+		}
+
+		if snap.Status != nil && snap.Status.ReadyToUse != nil && *snap.Status.ReadyToUse {
+			s.Timings.End = time.Now()
+			s.Timings.Duration = time.Since(s.Timings.Start).String()
+			klog.InfoS("snapshot ready", "namespace", *s.Namespace, "name", *s.Name, "duration", s.Timings.Duration)
+			return true, nil
+		}
+
+		return false, nil
+	})
+}
+
+func (s *Snapshot) Delete(ctx context.Context) error {
+	klog.InfoS("snapshot deleting", "namespace", *s.Namespace, "name", *s.Name)
+	s.Timings.Start = time.Now()
+	deletePolicy := metav1.DeletePropagationForeground
+	err := withRetry(ctx, *apiRetryInterval, *apiRetryMax, func() error {
+		return s.ClientSet.SnapshotV1().VolumeSnapshots(*s.Namespace).
+			Delete(ctx, *s.Name, metav1.DeleteOptions{PropagationPolicy: &deletePolicy})
+	})
+	if err != nil {
+		return err
+	}
+
+	klog.InfoS("snapshot deletion started", "namespace", *s.Namespace, "name", *s.Name)
+	return nil
+}
+
+func (s *Snapshot) WaitDelete(ctx context.Context) error {
+	return wait.PollUntilContextTimeout(ctx, *apiRetryInterval, *pollTimeout, true, func(ctx context.Context) (bool, error) {
+		klog.InfoS("snapshot waiting to be deleted", "namespace", *s.Namespace, "name", *s.Name)
+		_, err := s.ClientSet.SnapshotV1().VolumeSnapshots(*s.Namespace).Get(ctx, *s.Name, metav1.GetOptions{})
+		if k8serr.IsNotFound(err) {
+			s.Timings.End = time.Now()
+			s.Timings.Duration = time.Since(s.Timings.Start).String()
+			klog.InfoS("snapshot deleted", "namespace", *s.Namespace, "name", *s.Name, "duration", s.Timings.Duration)
+			return true, nil
+		}
+
+		if isRetryableAPIError(err) {
+			return false, nil
+		}
+
+		return false, err
+	})
 }
 
 /////////
 // Pod //
 /////////
-func newPod(ns, name, image, pvcName string) *corev1.Pod {
+// newPod builds the Pod for a run. If template is set (--pod-template), it is
+// used as-is, with only metadata.name/namespace and the claimName of any
+// PersistentVolumeClaim volume overridden so it binds to this run's generated
+// pair; everything else (containers, resources, security context, mount
+// options, ...) comes from the template.
+func newPod(ns, name, image, pvcName string, template *corev1.Pod) *corev1.Pod {
+	if template != nil {
+		pod := template.DeepCopy()
+		pod.ObjectMeta.Name = name
+		pod.ObjectMeta.Namespace = ns
+		for i := range pod.Spec.Volumes {
+			if pod.Spec.Volumes[i].PersistentVolumeClaim != nil {
+				pod.Spec.Volumes[i].PersistentVolumeClaim.ClaimName = pvcName
+			}
+		}
+		return pod
+	}
+
 	return &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
@@ -218,23 +720,29 @@ func newPod(ns, name, image, pvcName string) *corev1.Pod {
 	}
 }
 
-func (p *Pod) Create() error {
-	fmt.Printf(">>> [POD] %v/%v creating...\n", *p.Namespace, *p.Name)
-	pod := newPod(*p.Namespace, *p.Name, *p.Image, *p.Name)
+func (p *Pod) Create(ctx context.Context) error {
+	klog.InfoS("pod creating", "namespace", *p.Namespace, "name", *p.Name)
+	pod := newPod(*p.Namespace, *p.Name, *p.Image, *p.Name, p.Template)
 	p.Timings.Start = time.Now()
-	_, err := p.ClientSet.CoreV1().Pods(*p.Namespace).Create(context.TODO(), pod, metav1.CreateOptions{})
+	err := withRetry(ctx, *apiRetryInterval, *apiRetryMax, func() error {
+		_, err := p.ClientSet.CoreV1().Pods(*p.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	fmt.Printf(">>> [POD] %v/%v created\n", *p.Namespace, *p.Name)
+	klog.InfoS("pod created", "namespace", *p.Namespace, "name", *p.Name)
 	return nil
 }
 
-func (p *Pod) WaitCreate() error {
-	return wait.PollImmediate(APICallRetryInterval, DefaultPollTimeout, func() (bool, error) {
-		fmt.Printf(">>> [POD] %v/%v waiting to be ready....\n", *p.Namespace, *p.Name)
-		pod, err := p.ClientSet.CoreV1().Pods(*p.Namespace).Get(context.TODO(), *p.Name, metav1.GetOptions{})
+func (p *Pod) WaitCreate(ctx context.Context) error {
+	return wait.PollUntilContextTimeout(ctx, *apiRetryInterval, *pollTimeout, true, func(ctx context.Context) (bool, error) {
+		klog.InfoS("pod waiting to be ready", "namespace", *p.Namespace, "name", *p.Name)
+		pod, err := p.ClientSet.CoreV1().Pods(*p.Namespace).Get(ctx, *p.Name, metav1.GetOptions{})
 		if err != nil {
+			if isRetryableAPIError(err) {
+				return false, nil
+			}
 			return false, err
 		}
 
@@ -243,7 +751,8 @@ func (p *Pod) WaitCreate() error {
 				if condition.Status == corev1.ConditionTrue {
 					p.Timings.End = time.Now()
 					p.Timings.Duration = time.Since(p.Timings.Start).String()
-					logSuccess(fmt.Sprintf(">>> [POD] %v/%v ready, time elapsed: %v\n", *p.Namespace, *p.Name, p.Timings.Duration))
+					podReadySeconds.Observe(time.Since(p.Timings.Start).Seconds())
+					klog.InfoS("pod ready", "namespace", *p.Namespace, "name", *p.Name, "duration", p.Timings.Duration)
 					return true, nil
 				}
 			}
@@ -253,63 +762,229 @@ func (p *Pod) WaitCreate() error {
 	})
 }
 
-func (p *Pod) Delete() error {
-	fmt.Printf(">>> [POD] %v/%v deleting...\n", *p.Namespace, *p.Name)
+func (p *Pod) Delete(ctx context.Context) error {
+	klog.InfoS("pod deleting", "namespace", *p.Namespace, "name", *p.Name)
 	p.Timings.Start = time.Now()
 	deletePolicy := metav1.DeletePropagationForeground
-	err := p.ClientSet.CoreV1().Pods(*p.Namespace).
-		Delete(context.TODO(), *p.Name, metav1.DeleteOptions{PropagationPolicy: &deletePolicy})
+	err := withRetry(ctx, *apiRetryInterval, *apiRetryMax, func() error {
+		return p.ClientSet.CoreV1().Pods(*p.Namespace).
+			Delete(ctx, *p.Name, metav1.DeleteOptions{PropagationPolicy: &deletePolicy})
+	})
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf(">>> [POD] %v/%v deleting started\n", *p.Namespace, *p.Name)
+	klog.InfoS("pod deletion started", "namespace", *p.Namespace, "name", *p.Name)
 	return nil
 }
 
-func (p *Pod) WaitDelete() error {
-	return wait.PollImmediate(APICallRetryInterval, DefaultPollTimeout, func() (bool, error) {
-		fmt.Printf(">>> [POD] %v/%v waiting to be deleted....\n", *p.Namespace, *p.Name)
-		_, err := p.ClientSet.CoreV1().Pods(*p.Namespace).Get(context.TODO(), *p.Name, metav1.GetOptions{})
+func (p *Pod) WaitDelete(ctx context.Context) error {
+	return wait.PollUntilContextTimeout(ctx, *apiRetryInterval, *pollTimeout, true, func(ctx context.Context) (bool, error) {
+		klog.InfoS("pod waiting to be deleted", "namespace", *p.Namespace, "name", *p.Name)
+		_, err := p.ClientSet.CoreV1().Pods(*p.Namespace).Get(ctx, *p.Name, metav1.GetOptions{})
 		if k8serr.IsNotFound(err) {
 			p.Timings.End = time.Now()
 			p.Timings.Duration = time.Since(p.Timings.Start).String()
-			logSuccess(fmt.Sprintf(">>> [POD] %v/%v deleted, time elapsed: %v\n", *p.Namespace, *p.Name, p.Timings.Duration))
+			podDeleteSeconds.Observe(time.Since(p.Timings.Start).Seconds())
+			klog.InfoS("pod deleted", "namespace", *p.Namespace, "name", *p.Name, "duration", p.Timings.Duration)
 			return true, nil
 		}
 
+		if isRetryableAPIError(err) {
+			return false, nil
+		}
+
 		return false, err
 	})
 }
 
-func Deploy(rsc Resource, errChan chan<- error, doneChan chan<- bool) {
-	errCreate := rsc.Create()
+// RunIOBenchmark execs a fio run inside the pod's container at MountPath and
+// records the resulting throughput/IOPS/latency in p.IOResults.
+func (p *Pod) RunIOBenchmark(ctx context.Context, blockSize, duration, size string, jobs int) error {
+	klog.InfoS("pod running io benchmark", "namespace", *p.Namespace, "name", *p.Name)
+
+	script := fmt.Sprintf(
+		"fio --name=%v --directory=%v --rw=randrw --bs=%v --size=%v --runtime=%v --time_based --numjobs=%v --group_reporting --output-format=json",
+		AppName, MountPath, blockSize, size, duration, jobs,
+	)
+
+	req := p.ClientSet.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(*p.Namespace).
+		Name(*p.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Command: []string{"sh", "-c", script},
+			Stdout:  true,
+			Stderr:  true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(p.RestConfig, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := executor.StreamWithContext(ctx, remotecommand.StreamOptions{Stdout: &stdout, Stderr: &stderr}); err != nil {
+		return fmt.Errorf("fio exec failed: %w (stderr: %v)", err, stderr.String())
+	}
+
+	results, err := parseFioResults(stdout.Bytes())
+	if err != nil {
+		return err
+	}
+
+	p.IOResults = results
+	klog.InfoS("pod io benchmark done",
+		"namespace", *p.Namespace, "name", *p.Name,
+		"bandwidthMBps", results.BandwidthMBps, "iops", results.IOPS,
+		"readLatencyP50Ms", results.ReadLatencyP50Ms, "readLatencyP99Ms", results.ReadLatencyP99Ms,
+		"writeLatencyP50Ms", results.WriteLatencyP50Ms, "writeLatencyP99Ms", results.WriteLatencyP99Ms)
+	return nil
+}
+
+// fioJSONOutput is the subset of fio's --output-format=json schema used to
+// populate IOResults.
+type fioJSONOutput struct {
+	Jobs []struct {
+		Read struct {
+			BWBytes float64 `json:"bw_bytes"`
+			IOPS    float64 `json:"iops"`
+			ClatNs  struct {
+				Percentile map[string]float64 `json:"percentile"`
+			} `json:"clat_ns"`
+		} `json:"read"`
+		Write struct {
+			BWBytes float64 `json:"bw_bytes"`
+			IOPS    float64 `json:"iops"`
+			ClatNs  struct {
+				Percentile map[string]float64 `json:"percentile"`
+			} `json:"clat_ns"`
+		} `json:"write"`
+	} `json:"jobs"`
+}
+
+func parseFioResults(raw []byte) (*IOResults, error) {
+	var out fioJSONOutput
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+
+	if len(out.Jobs) == 0 {
+		return nil, fmt.Errorf("fio produced no job results")
+	}
+
+	job := out.Jobs[0]
+	return &IOResults{
+		BandwidthMBps:     (job.Read.BWBytes + job.Write.BWBytes) / (1024 * 1024),
+		IOPS:              job.Read.IOPS + job.Write.IOPS,
+		ReadLatencyP50Ms:  job.Read.ClatNs.Percentile["50.000000"] / 1e6,
+		ReadLatencyP99Ms:  job.Read.ClatNs.Percentile["99.000000"] / 1e6,
+		WriteLatencyP50Ms: job.Write.ClatNs.Percentile["50.000000"] / 1e6,
+		WriteLatencyP99Ms: job.Write.ClatNs.Percentile["99.000000"] / 1e6,
+	}, nil
+}
+
+func Deploy(ctx context.Context, rsc Resource, errChan chan<- error, doneChan chan<- bool) {
+	errCreate := rsc.Create(ctx)
 	if errCreate != nil {
+		createErrorsTotal.WithLabelValues(errorReason(errCreate)).Inc()
 		errChan <- errCreate
 	}
 
-	errWait := rsc.WaitCreate()
+	errWait := rsc.WaitCreate(ctx)
 	if errWait != nil {
+		createErrorsTotal.WithLabelValues(errorReason(errWait)).Inc()
 		errChan <- errWait
+	} else {
+		// Mirrors Clean's Dec(): count the resource as in-flight once it is
+		// confirmed to exist, whether this call created it or it was already
+		// there (AlreadyExists), so the gauge can't under-count.
+		inFlightResources.Inc()
 	}
 
 	doneChan <- true
 }
 
-func Clean(rsc Resource, errChan chan<- error, doneChan chan<- bool) {
-	errCreate := rsc.Delete()
+func Clean(ctx context.Context, rsc Resource, errChan chan<- error, doneChan chan<- bool) {
+	errCreate := rsc.Delete(ctx)
 	if errCreate != nil {
+		deleteErrorsTotal.WithLabelValues(errorReason(errCreate)).Inc()
 		errChan <- errCreate
 	}
 
-	errWait := rsc.WaitDelete()
+	errWait := rsc.WaitDelete(ctx)
 	if errWait != nil {
+		deleteErrorsTotal.WithLabelValues(errorReason(errWait)).Inc()
 		errChan <- errWait
+	} else {
+		inFlightResources.Dec()
 	}
 
 	doneChan <- true
 }
 
+// loadPriorSnapshotNames reads a previous results json file and returns the
+// VolumeSnapshot names it recorded, in the same order as the PVCs that were
+// deployed, so a --restore-from-snapshot run can bind each new PVC to the
+// matching snapshot.
+//
+// This run only times the restored PVCs' own Pvc.Timings; it does not
+// provision a paired fresh PVC to diff against. The "restore-bound vs. a
+// fresh PVC" comparison is made by reading the fresh-PVC Pvc.Timings already
+// recorded in this same results file, from the plain (non-restore) run that
+// produced the snapshots being restored from.
+func loadPriorSnapshotNames(path string) ([]string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var prior PodWithPvc
+	if err := json.Unmarshal(raw, &prior); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(prior.Snapshot))
+	for _, snap := range prior.Snapshot {
+		names = append(names, *snap.Name)
+	}
+
+	return names, nil
+}
+
+// loadPodTemplate decodes a yaml Pod manifest for use as the base of every
+// pod created by --pod-template.
+func loadPodTemplate(path string) (*corev1.Pod, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pod corev1.Pod
+	if err := k8syaml.NewYAMLOrJSONDecoder(bytes.NewReader(raw), 4096).Decode(&pod); err != nil {
+		return nil, err
+	}
+
+	return &pod, nil
+}
+
+// loadPvcTemplate decodes a yaml PersistentVolumeClaim manifest for use as
+// the base of every PVC created by --pvc-template.
+func loadPvcTemplate(path string) (*corev1.PersistentVolumeClaim, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pvc corev1.PersistentVolumeClaim
+	if err := k8syaml.NewYAMLOrJSONDecoder(bytes.NewReader(raw), 4096).Decode(&pvc); err != nil {
+		return nil, err
+	}
+
+	return &pvc, nil
+}
+
 func parseArgs(name string, args []string) *flag.FlagSet {
 	fs := flag.NewFlagSet(name, flag.ExitOnError)
 
@@ -324,12 +999,281 @@ func parseArgs(name string, args []string) *flag.FlagSet {
 	count = fs.Int("count", DefaultCount, "Number of pod with pvc to create")
 	storageClass = fs.String("storage-class", "", "Storage Class of the PersistentVolumeClaims (mandatory)")
 	reqStorageSize = fs.String("pvc-size", DefaultSize, "Requested size of the PersistentVolumeClaims")
+	snapshotClass = fs.String("snapshot-class", "", "VolumeSnapshotClass used to snapshot each PVC once bound (disabled if empty)")
+	restoreFromSnapshot = fs.String("restore-from-snapshot", "", "path to a previous results json file whose VolumeSnapshots are restored into the new PVCs; compare this run's Pvc.Timings against that file's own (fresh, non-restore) Pvc.Timings to get restore-bound vs. fresh-bound latency")
+	cloneFrom = fs.Bool("clone-from", false, "for each bound PVC, create an additional PVC cloned from it and time its bind latency")
+	expandTo = fs.String("expand-to", "", "resize to request after the PVCs are bound, e.g. 200m (disabled if empty)")
+	ioBenchmark = fs.Bool("io-benchmark", false, "run a fio I/O micro-benchmark inside each pod once ready")
+	ioImage = fs.String("io-image", DefaultIOImage, "pod image used when --io-benchmark is set (must have fio installed)")
+	ioBlockSize = fs.String("io-block-size", DefaultIOBlockSize, "fio block size for --io-benchmark")
+	ioDuration = fs.String("io-duration", DefaultIODuration, "fio run duration for --io-benchmark")
+	ioJobs = fs.Int("io-jobs", DefaultIOJobs, "fio number of jobs for --io-benchmark")
+	ioSize = fs.String("io-size", DefaultIOSize, "fio total file size per job for --io-benchmark (must fit within --pvc-size)")
+	apiRetryInterval = fs.Duration("api-retry-interval", DefaultAPIRetryInterval, "initial backoff interval between retries of a retryable API error")
+	apiRetryMax = fs.Duration("api-retry-max", DefaultAPIRetryMax, "total time budget for retrying a retryable API error before giving up")
+	pollTimeout = fs.Duration("poll-timeout", DefaultPollTimeout, "timeout waiting for a resource to reach its expected state")
+	podTemplate = fs.String("pod-template", "", "path to a yaml Pod manifest used as the base for every pod (overrides metadata.name/namespace and the PVC volume's claimName)")
+	pvcTemplate = fs.String("pvc-template", "", "path to a yaml PersistentVolumeClaim manifest used as the base for every PVC (overrides metadata.name/namespace, plus spec.dataSource for clone/restore PVCs)")
+	metricsAddr = fs.String("metrics-addr", "", "address (e.g. :9090) to serve Prometheus metrics on (disabled if empty)")
+	logFormat = fs.String("log-format", DefaultLogFormat, "log output format: text or json")
+	repeat = fs.Int("repeat", DefaultRepeat, "number of times to repeat the run, for continuous soak testing (0 repeats forever)")
+	interval = fs.Duration("interval", 0, "time to wait between repeated runs when --repeat is not 1")
 
 	fs.Parse(args[2:])
 
 	return fs
 }
 
+// shutdown runs onCancel, if set, to clean up whatever was already created,
+// then exits the process. It is the single path out of waitN for the run's
+// top-level ctx actually being cancelled (SIGINT/SIGTERM).
+func shutdown(onCancel func()) {
+	if onCancel != nil {
+		onCancel()
+	}
+	os.Exit(1)
+}
+
+// waitN drains doneChan/deployErrChan/cleanErrChan until n resources report
+// done. Errors other than the expected AlreadyExists/NotFound outcomes are
+// logged and counted, but do not abort the run: one resource's fio exec
+// failure, failed expansion, or --poll-timeout while waiting for it are
+// per-resource problems, not a reason to discard every other result already
+// collected. Only the top-level ctx itself being cancelled (checked via
+// ctx.Err(), not by inspecting an error value — a per-resource poll that
+// merely times out also returns context.DeadlineExceeded, from its own,
+// unrelated, --poll-timeout deadline) is treated as the shutdown signal, so
+// it still runs onCancel instead of leaving orphaned resources.
+func waitN(ctx context.Context, n int, doneChan <-chan bool, deployErrChan, cleanErrChan <-chan error, onCancel func()) {
+	done := 0
+	for {
+		select {
+		case <-ctx.Done():
+			shutdown(onCancel)
+		case err := <-deployErrChan:
+			if ctx.Err() != nil {
+				shutdown(onCancel)
+			}
+			if !k8serr.IsAlreadyExists(err) {
+				klog.ErrorS(err, "resource create/wait failed, continuing run")
+			}
+		case err := <-cleanErrChan:
+			if ctx.Err() != nil {
+				shutdown(onCancel)
+			}
+			if !k8serr.IsNotFound(err) {
+				klog.ErrorS(err, "resource delete/wait failed, continuing run")
+			}
+		case <-doneChan:
+			done++
+		}
+		if done == n {
+			return
+		}
+	}
+}
+
+// runConfig bundles the clients and templates a run needs, so repeated
+// iterations (--repeat) don't have to rebuild them or thread a long
+// parameter list through main.
+type runConfig struct {
+	clientset      *kubernetes.Clientset
+	snapClientset  *snapshotclientset.Clientset
+	restConfig     *rest.Config
+	priorSnapshots []string
+	podTemplate    *corev1.Pod
+	pvcTemplate    *corev1.PersistentVolumeClaim
+}
+
+// run executes one deploy/clean pass over --count pods and PVCs, plus any of
+// the clone/expand/io-benchmark/snapshot phases requested, and returns the
+// collected results.
+func run(ctx context.Context, cfg *runConfig) *PodWithPvc {
+	pvcs := make([]*Pvc, 0, *count)
+	pods := make([]*Pod, 0, *count)
+	snapshots := make([]*Snapshot, 0, *count)
+
+	doneChan := make(chan bool)
+	deployErrChan := make(chan error)
+	cleanErrChan := make(chan error)
+
+	podImage := image
+	if *ioBenchmark {
+		podImage = ioImage
+	}
+
+	start := time.Now()
+	klog.InfoS("run starting", "time", start)
+	for i := 1; i <= *count; i++ {
+		name := fmt.Sprintf("%v-%04d", *prefix, i)
+
+		// Generate PVC
+		pvc := Pvc{Namespace: namespace, Name: &name, ClientSet: cfg.clientset, Size: reqStorageSize, StorageClass: storageClass, Template: cfg.pvcTemplate}
+		if i-1 < len(cfg.priorSnapshots) {
+			pvc.RestoreFromSnapshot = &cfg.priorSnapshots[i-1]
+		}
+		pvcs = append(pvcs, &pvc)
+
+		// Generate POD
+		pod := Pod{Namespace: namespace, Name: &name, Image: podImage, ClientSet: cfg.clientset, RestConfig: cfg.restConfig, Template: cfg.podTemplate}
+		pods = append(pods, &pod)
+
+		switch fs.Name() {
+		case "deploy":
+			go Deploy(ctx, &pvc, deployErrChan, doneChan)
+			go Deploy(ctx, &pod, deployErrChan, doneChan)
+			time.Sleep(time.Duration(rand.Intn(DefaultSleepMilliseconds)) * time.Millisecond)
+		case "clean":
+			go Clean(ctx, &pvc, cleanErrChan, doneChan)
+			go Clean(ctx, &pod, cleanErrChan, doneChan)
+			time.Sleep(time.Duration(rand.Intn(DefaultSleepMilliseconds)) * time.Millisecond)
+		}
+	}
+
+	// cleanupOnCancel makes a best-effort attempt to delete whatever PVCs and
+	// pods have been created so far, using a fresh context since ctx itself
+	// is the one that was just cancelled.
+	cleanupOnCancel := func() {
+		klog.InfoS("cleaning up in-flight resources before exiting")
+		bg := context.Background()
+		for _, pvc := range pvcs {
+			_ = pvc.Delete(bg)
+		}
+		for _, pod := range pods {
+			_ = pod.Delete(bg)
+		}
+	}
+
+	// POD+PVC = 2
+	waitN(ctx, *count*2, doneChan, deployErrChan, cleanErrChan, cleanupOnCancel)
+
+	// originalPvcs is kept separate from pvcs (which --clone-from below
+	// appends the "-clone" PVCs onto) so --expand-to only resizes the PVCs
+	// the run actually created for --count, not their clones too.
+	originalPvcs := append([]*Pvc(nil), pvcs...)
+
+	if *cloneFrom {
+		sourcePvcs := pvcs
+		clonePvcs := make([]*Pvc, 0, len(sourcePvcs))
+		for _, pvc := range sourcePvcs {
+			cloneName := *pvc.Name + "-clone"
+			clonePvc := Pvc{Namespace: namespace, Name: &cloneName, ClientSet: cfg.clientset, Size: reqStorageSize, StorageClass: storageClass, SourcePVC: pvc.Name, Template: cfg.pvcTemplate}
+			clonePvcs = append(clonePvcs, &clonePvc)
+
+			switch fs.Name() {
+			case "deploy":
+				go Deploy(ctx, &clonePvc, deployErrChan, doneChan)
+			case "clean":
+				go Clean(ctx, &clonePvc, cleanErrChan, doneChan)
+			}
+		}
+
+		pvcs = append(pvcs, clonePvcs...)
+		waitN(ctx, len(clonePvcs), doneChan, deployErrChan, cleanErrChan, cleanupOnCancel)
+
+		for _, clonePvc := range clonePvcs {
+			cloneTiming := clonePvc.Timings
+			clonePvc.CloneTiming = &cloneTiming
+		}
+	}
+
+	if *expandTo != "" && fs.Name() == "deploy" {
+		for _, pvc := range originalPvcs {
+			targetSize := *expandTo
+			pvc.TargetSize = &targetSize
+
+			go func(p *Pvc) {
+				if err := p.Expand(ctx); err != nil {
+					createErrorsTotal.WithLabelValues(errorReason(err)).Inc()
+					deployErrChan <- err
+					doneChan <- true
+					return
+				}
+				if err := p.WaitExpand(ctx); err != nil {
+					createErrorsTotal.WithLabelValues(errorReason(err)).Inc()
+					deployErrChan <- err
+				}
+				doneChan <- true
+			}(pvc)
+		}
+
+		waitN(ctx, len(originalPvcs), doneChan, deployErrChan, cleanErrChan, cleanupOnCancel)
+	}
+
+	if *ioBenchmark && fs.Name() == "deploy" {
+		for _, pod := range pods {
+			go func(p *Pod) {
+				if err := p.RunIOBenchmark(ctx, *ioBlockSize, *ioDuration, *ioSize, *ioJobs); err != nil {
+					createErrorsTotal.WithLabelValues(errorReason(err)).Inc()
+					deployErrChan <- err
+				}
+				doneChan <- true
+			}(pod)
+		}
+
+		waitN(ctx, len(pods), doneChan, deployErrChan, cleanErrChan, cleanupOnCancel)
+	}
+
+	if *snapshotClass != "" {
+		for _, pvc := range pvcs {
+			snapName := *pvc.Name + "-snap"
+			snapshot := Snapshot{Namespace: namespace, Name: &snapName, ClientSet: cfg.snapClientset, SourcePVC: pvc.Name, SnapshotClass: snapshotClass}
+			snapshots = append(snapshots, &snapshot)
+
+			switch fs.Name() {
+			case "deploy":
+				go Deploy(ctx, &snapshot, deployErrChan, doneChan)
+			case "clean":
+				go Clean(ctx, &snapshot, cleanErrChan, doneChan)
+			}
+		}
+
+		waitN(ctx, len(snapshots), doneChan, deployErrChan, cleanErrChan, cleanupOnCancel)
+	}
+
+	klog.InfoS("run finished", "time", time.Now(), "duration", time.Since(start).String())
+
+	return &PodWithPvc{
+		Namespace: namespace,
+		Command:   fs.Name(),
+		Pvc:       pvcs,
+		Pod:       pods,
+		Snapshot:  snapshots,
+	}
+}
+
+// writeResults honours --results-file/--results-stdout/--no-results for one
+// run's output. When --repeat produces more than one run, each iteration's
+// results file is suffixed with its iteration number so soak-testing runs
+// don't clobber one another.
+func writeResults(pwp *PodWithPvc, iteration, repeatCount int) {
+	if *noResults {
+		return
+	}
+
+	outputMarshal, err := json.MarshalIndent(pwp, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+
+	if !*noResultsFile && *resultsFile != "" {
+		path := *resultsFile
+		if repeatCount != 1 {
+			path = fmt.Sprintf("%v.%d", *resultsFile, iteration)
+		}
+
+		klog.InfoS("writing results", "path", path)
+		if err := ioutil.WriteFile(path, outputMarshal, 0644); err != nil {
+			panic(err)
+		}
+		klog.InfoS("results successfully written to file", "path", path)
+	}
+
+	if *resultsStdout {
+		fmt.Println(string(outputMarshal))
+	}
+}
+
 func main() {
 	args := os.Args
 	if len(args) < 2 {
@@ -355,6 +1299,23 @@ func main() {
 		os.Exit(1)
 	}
 
+	configureLogging(*logFormat)
+
+	if *metricsAddr != "" {
+		serveMetrics(*metricsAddr)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		klog.InfoS("received interrupt, cancelling in-flight operations")
+		cancel()
+	}()
+
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 	if err != nil {
 		panic(err)
@@ -365,85 +1326,65 @@ func main() {
 		panic(err)
 	}
 
-	pvcs := make([]*Pvc, 0, *count)
-	pods := make([]*Pod, 0, *count)
-
-	doneChan := make(chan bool)
-	deployErrChan := make(chan error)
-	cleanErrChan := make(chan error)
-
-	start := time.Now()
-	fmt.Println(">>> Starting:", start)
-	for i := 1; i <= *count; i++ {
-		name := fmt.Sprintf("%v-%04d", *prefix, i)
-
-		// Generate PVC
-		pvc := Pvc{Namespace: namespace, Name: &name, ClientSet: clientset, Size: reqStorageSize, StorageClass: storageClass}
-		pvcs = append(pvcs, &pvc)
-
-		// Generate POD
-		pod := Pod{Namespace: namespace, Name: &name, Image: image, ClientSet: clientset}
-		pods = append(pods, &pod)
-
-		switch fs.Name() {
-		case "deploy":
-			go Deploy(&pvc, deployErrChan, doneChan)
-			go Deploy(&pod, deployErrChan, doneChan)
-			time.Sleep(time.Duration(rand.Intn(DefaultSleepMilliseconds)) * time.Millisecond)
-		case "clean":
-			go Clean(&pvc, cleanErrChan, doneChan)
-			go Clean(&pod, cleanErrChan, doneChan)
-			time.Sleep(time.Duration(rand.Intn(DefaultSleepMilliseconds)) * time.Millisecond)
+	var snapClientset *snapshotclientset.Clientset
+	if *snapshotClass != "" || *restoreFromSnapshot != "" {
+		snapClientset, err = snapshotclientset.NewForConfig(config)
+		if err != nil {
+			panic(err)
 		}
 	}
 
-	i := 0
-	for {
-		select {
-		case err := <-deployErrChan:
-			if !(k8serr.IsAlreadyExists(err)) {
-				panic(err)
-			}
-		case err := <-cleanErrChan:
-			if !(k8serr.IsNotFound(err)) {
-				panic(err)
-			}
-		case <-doneChan:
-			i++
-		}
-		// POD+PVC = 2
-		if i == (*count * 2) {
-			break
+	var priorSnapshots []string
+	if *restoreFromSnapshot != "" {
+		priorSnapshots, err = loadPriorSnapshotNames(*restoreFromSnapshot)
+		if err != nil {
+			panic(err)
 		}
 	}
 
-	pwp := PodWithPvc{
-		Namespace: namespace,
-		Command:   fs.Name(),
-		Pvc:       pvcs,
-		Pod:       pods,
+	var podTemplateObj *corev1.Pod
+	if *podTemplate != "" {
+		podTemplateObj, err = loadPodTemplate(*podTemplate)
+		if err != nil {
+			panic(err)
+		}
 	}
 
-	if !*noResults {
-		outputMarshal, err := json.MarshalIndent(pwp, "", "  ")
+	var pvcTemplateObj *corev1.PersistentVolumeClaim
+	if *pvcTemplate != "" {
+		pvcTemplateObj, err = loadPvcTemplate(*pvcTemplate)
 		if err != nil {
 			panic(err)
 		}
+	}
 
-		if !*noResultsFile && *resultsFile != "" {
-			fmt.Printf(">>> Writing results to: %v\n", *resultsFile)
-			err := ioutil.WriteFile(*resultsFile, outputMarshal, 0644)
-			if err != nil {
-				panic(err)
-			}
-			logSuccess(">>> Results successfully written to file")
+	cfg := &runConfig{
+		clientset:      clientset,
+		snapClientset:  snapClientset,
+		restConfig:     config,
+		priorSnapshots: priorSnapshots,
+		podTemplate:    podTemplateObj,
+		pvcTemplate:    pvcTemplateObj,
+	}
+
+	// --repeat 0 means "loop forever" for continuous soak testing; any other
+	// value runs that many times.
+	for iteration := 1; *repeat == 0 || iteration <= *repeat; iteration++ {
+		if *repeat != 1 {
+			klog.InfoS("starting iteration", "iteration", iteration, "repeat", *repeat)
 		}
 
-		if *resultsStdout {
-			fmt.Println(string(outputMarshal))
+		pwp := run(ctx, cfg)
+		writeResults(pwp, iteration, *repeat)
+
+		if *repeat == 1 || (*repeat != 0 && iteration == *repeat) {
+			break
 		}
-	}
 
-	fmt.Println(">>> Finished:", time.Now())
-	fmt.Println(">>> Duration:", time.Since(start))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(*interval):
+		}
+	}
 }
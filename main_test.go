@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestParseFioResults(t *testing.T) {
+	raw := []byte(`{
+		"jobs": [
+			{
+				"read":  {"bw_bytes": 1000000, "iops": 100, "clat_ns": {"percentile": {"50.000000": 1000000, "99.000000": 5000000}}},
+				"write": {"bw_bytes": 2000000, "iops": 200, "clat_ns": {"percentile": {"50.000000": 3000000, "99.000000": 9000000}}}
+			}
+		]
+	}`)
+
+	results, err := parseFioResults(raw)
+	if err != nil {
+		t.Fatalf("parseFioResults() error = %v", err)
+	}
+
+	if got, want := results.BandwidthMBps, 3000000.0/(1024*1024); got != want {
+		t.Errorf("BandwidthMBps = %v, want %v", got, want)
+	}
+	if got, want := results.IOPS, 300.0; got != want {
+		t.Errorf("IOPS = %v, want %v", got, want)
+	}
+	if got, want := results.ReadLatencyP50Ms, 1.0; got != want {
+		t.Errorf("ReadLatencyP50Ms = %v, want %v", got, want)
+	}
+	if got, want := results.ReadLatencyP99Ms, 5.0; got != want {
+		t.Errorf("ReadLatencyP99Ms = %v, want %v", got, want)
+	}
+	if got, want := results.WriteLatencyP50Ms, 3.0; got != want {
+		t.Errorf("WriteLatencyP50Ms = %v, want %v", got, want)
+	}
+	if got, want := results.WriteLatencyP99Ms, 9.0; got != want {
+		t.Errorf("WriteLatencyP99Ms = %v, want %v", got, want)
+	}
+}
+
+func TestParseFioResultsNoJobs(t *testing.T) {
+	if _, err := parseFioResults([]byte(`{"jobs": []}`)); err == nil {
+		t.Fatal("parseFioResults() with no jobs: expected error, got nil")
+	}
+}
+
+// timeoutError is a minimal net.Error stand-in for a timed-out connection.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "dial tcp: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Error = timeoutError{}
+
+func TestIsRetryableAPIError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "server timeout", err: k8serr.NewServerTimeout(schema.GroupResource{Resource: "pods"}, "get", 1), want: true},
+		{name: "too many requests", err: k8serr.NewTooManyRequests("busy", 1), want: true},
+		{name: "internal error", err: k8serr.NewInternalError(errors.New("boom")), want: true},
+		{name: "net timeout", err: timeoutError{}, want: true},
+		{name: "wrapped EOF", err: fmt.Errorf("reading response: %w", errors.New("unexpected EOF")), want: true},
+		{name: "connection refused", err: errors.New("dial tcp: connection refused"), want: true},
+		{name: "already exists", err: k8serr.NewAlreadyExists(schema.GroupResource{Resource: "pods"}, "foo"), want: false},
+		{name: "not found", err: k8serr.NewNotFound(schema.GroupResource{Resource: "pods"}, "foo"), want: false},
+		{name: "other error", err: errors.New("something unrelated"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableAPIError(tt.err); got != tt.want {
+				t.Errorf("isRetryableAPIError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "nil", err: nil, want: ""},
+		{name: "already exists", err: k8serr.NewAlreadyExists(schema.GroupResource{Resource: "pods"}, "foo"), want: "AlreadyExists"},
+		{name: "not found", err: k8serr.NewNotFound(schema.GroupResource{Resource: "pods"}, "foo"), want: "NotFound"},
+		{name: "retryable", err: k8serr.NewTooManyRequests("busy", 1), want: "Retryable"},
+		{name: "fatal", err: errors.New("something unrelated"), want: "Fatal"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorReason(tt.err); got != tt.want {
+				t.Errorf("errorReason(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPvClaim(t *testing.T) {
+	sc := "standard"
+	cloneSource := &corev1.TypedLocalObjectReference{Kind: "PersistentVolumeClaim", Name: "source"}
+
+	template := &corev1.PersistentVolumeClaim{
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		template   *corev1.PersistentVolumeClaim
+		dataSource *corev1.TypedLocalObjectReference
+	}{
+		{name: "no template, no dataSource", template: nil, dataSource: nil},
+		{name: "no template, with dataSource", template: nil, dataSource: cloneSource},
+		{name: "template, no dataSource", template: template, dataSource: nil},
+		{name: "template, with dataSource (clone-from/restore combined with --pvc-template)", template: template, dataSource: cloneSource},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := newPvClaim("ns", "name", "100m", &sc, tt.dataSource, tt.template)
+
+			if pvc.ObjectMeta.Name != "name" || pvc.ObjectMeta.Namespace != "ns" {
+				t.Fatalf("metadata = %+v, want name=name namespace=ns", pvc.ObjectMeta)
+			}
+			if pvc.Spec.DataSource != tt.dataSource {
+				t.Errorf("Spec.DataSource = %v, want %v", pvc.Spec.DataSource, tt.dataSource)
+			}
+			if tt.template != nil {
+				if len(pvc.Spec.AccessModes) != 1 || pvc.Spec.AccessModes[0] != corev1.ReadWriteMany {
+					t.Errorf("Spec.AccessModes = %v, want template's ReadWriteMany (template fields must survive)", pvc.Spec.AccessModes)
+				}
+			}
+		})
+	}
+}